@@ -0,0 +1,20 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIdentity returns the device and inode number backing filePath, as
+// reported by the platform's stat(2). It is used both to recognize
+// hardlinks to a file already seen and, with --cross-device=false, to keep
+// a recursive walk from crossing onto another filesystem.
+func fileIdentity(filePath string, info os.FileInfo) (dev, ino uint64, ok bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return uint64(stat.Dev), uint64(stat.Ino), true
+}
@@ -0,0 +1,38 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileIdentity returns the volume serial number and file index backing
+// filePath, Windows' equivalents of a Unix device and inode number. It is
+// used both to recognize hardlinks to a file already seen and, with
+// --cross-device=false, to keep a recursive walk from crossing onto
+// another volume.
+func fileIdentity(filePath string, info os.FileInfo) (dev, ino uint64, ok bool) {
+	pathPtr, err := windows.UTF16PtrFromString(filePath)
+	if err != nil {
+		return 0, 0, false
+	}
+	flags := uint32(windows.FILE_FLAG_BACKUP_SEMANTICS)
+	if info.Mode()&os.ModeSymlink != 0 {
+		flags |= windows.FILE_FLAG_OPEN_REPARSE_POINT
+	}
+	h, err := windows.CreateFile(pathPtr, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE, nil, windows.OPEN_EXISTING, flags, 0)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer windows.CloseHandle(h)
+
+	var fi windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(h, &fi); err != nil {
+		return 0, 0, false
+	}
+	dev = uint64(fi.VolumeSerialNumber)
+	ino = uint64(fi.FileIndexHigh)<<32 | uint64(fi.FileIndexLow)
+	return dev, ino, true
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"hash"
@@ -13,9 +15,12 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/blake2b"
@@ -23,11 +28,244 @@ import (
 	"golang.org/x/crypto/ripemd160"
 	"golang.org/x/crypto/sha3"
 
+	multihash "github.com/multiformats/go-multihash"
 	"github.com/pkg/xattr"
 )
 
-// A map to store hashes and the list of files with that hash
-var filesByHash = make(map[string][]string)
+// multihashXattrName is the single, self-describing extended attribute
+// used to cache digests across algorithms. It replaces the older
+// per-algorithm "user.same-hash.<algo>" naming scheme: a multihash encodes
+// its own algorithm code, so a file hashed with one algorithm can still be
+// verified after the user switches -a to another.
+const multihashXattrName = "user.same.multihash"
+
+// multihashMtimeXattrName stores the ModTime (RFC3339Nano) of the file at
+// the moment multihashXattrName was last written. Without this, -X would
+// happily hand back a digest for a file that has since been edited in
+// place; the cache is only trusted when the mtime still matches exactly.
+const multihashMtimeXattrName = multihashXattrName + ".mtime"
+
+// multihashCodes maps the algorithm names accepted by -a to their
+// multihash function codes. Algorithms with no standardized multihash code
+// (md4, ripemd160, sha224, sha384 - the latter two absent from
+// go-multihash itself) are omitted; -X/-Y reject these algorithms
+// up front rather than silently failing to cache per file.
+var multihashCodes = map[string]uint64{
+	"md5":      multihash.MD5,
+	"sha1":     multihash.SHA1,
+	"sha256":   multihash.SHA2_256,
+	"sha512":   multihash.SHA2_512,
+	"sha3-224": multihash.SHA3_224,
+	"sha3-256": multihash.SHA3_256,
+	"sha3-384": multihash.SHA3_384,
+	"sha3-512": multihash.SHA3_512,
+	"shake128": multihash.SHAKE_128,
+	"shake256": multihash.SHAKE_256,
+	"blake2b":  multihash.BLAKE2B_MAX,
+}
+
+// algoForMultihashCode is the inverse of multihashCodes, used when decoding
+// a stored multihash back into the -a algorithm name it was produced with.
+func algoForMultihashCode(code uint64) (string, bool) {
+	for algo, c := range multihashCodes {
+		if c == code {
+			return algo, true
+		}
+	}
+	return "", false
+}
+
+// encodeMultihash turns a hex digest produced by hashFile into a
+// base58-encoded multihash self-describing the algorithm it was hashed
+// with.
+func encodeMultihash(hexDigest, algo string) (string, error) {
+	code, ok := multihashCodes[strings.ToLower(algo)]
+	if !ok {
+		return "", fmt.Errorf("algorithm %s has no multihash code", algo)
+	}
+	digest, err := hex.DecodeString(hexDigest)
+	if err != nil {
+		return "", fmt.Errorf("decoding digest: %w", err)
+	}
+	mh, err := multihash.Encode(digest, code)
+	if err != nil {
+		return "", fmt.Errorf("encoding multihash: %w", err)
+	}
+	return multihash.Multihash(mh).B58String(), nil
+}
+
+// decodeMultihash recovers the algorithm name and hex digest from a
+// base58-encoded multihash previously produced by encodeMultihash.
+func decodeMultihash(encoded string) (algo string, hexDigest string, err error) {
+	raw, err := multihash.FromB58String(encoded)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding base58: %w", err)
+	}
+	decoded, err := multihash.Decode(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("decoding multihash: %w", err)
+	}
+	algo, ok := algoForMultihashCode(decoded.Code)
+	if !ok {
+		return "", "", fmt.Errorf("unsupported multihash code: 0x%x", decoded.Code)
+	}
+	return algo, fmt.Sprintf("%x", decoded.Digest), nil
+}
+
+// readMultihashXattr reads and decodes the multihash stored for filePath,
+// returning the lowercased algorithm name and hex digest it was produced
+// with.
+func readMultihashXattr(filePath string) (algo string, hexDigest string, err error) {
+	raw, err := xattr.Get(filePath, multihashXattrName)
+	if err != nil {
+		return "", "", err
+	}
+	return decodeMultihash(string(raw))
+}
+
+// writeMultihashXattr encodes fileHash as a multihash for hashAlgo and
+// stores it on filePath, together with the file's current ModTime so a
+// later run can tell whether the file has changed since. Failures are
+// logged, not fatal, matching the existing xattr-write error handling.
+func writeMultihashXattr(filePath, fileHash, hashAlgo string, info os.FileInfo) {
+	encoded, err := encodeMultihash(fileHash, hashAlgo)
+	if err != nil {
+		log.Printf("Error encoding multihash for %s: %v", filePath, err)
+		return
+	}
+	if err := xattr.Set(filePath, multihashXattrName, []byte(encoded)); err != nil {
+		log.Printf("Error writing xattr for %s: %v", filePath, err)
+		return
+	}
+	mtime := info.ModTime().Format(time.RFC3339Nano)
+	if err := xattr.Set(filePath, multihashMtimeXattrName, []byte(mtime)); err != nil {
+		log.Printf("Error writing mtime xattr for %s: %v", filePath, err)
+	}
+}
+
+// readCachedHash returns the digest cached in the multihash xattr for
+// filePath, but only when it was produced with hashAlgo and its companion
+// mtime attribute still matches info.ModTime() exactly. Otherwise
+// cacheValid is false and the caller should recompute.
+func readCachedHash(filePath string, info os.FileInfo, hashAlgo string) (hexDigest string, cacheValid bool) {
+	algo, digest, err := readMultihashXattr(filePath)
+	if err != nil || algo != strings.ToLower(hashAlgo) {
+		return "", false
+	}
+	storedMtime, err := xattr.Get(filePath, multihashMtimeXattrName)
+	if err != nil {
+		return "", false
+	}
+	mtime, err := time.Parse(time.RFC3339Nano, string(storedMtime))
+	if err != nil || !mtime.Equal(info.ModTime()) {
+		return "", false
+	}
+	return digest, true
+}
+
+// HashStore collects the hash -> paths mapping produced concurrently by the
+// worker pool in hashPaths, along with each path's size so the json
+// formatter can report it without re-statting every file. All writes go
+// through add and checkInode, which are safe to call from multiple
+// goroutines; the formatters read the maps directly once hashing is done.
+type HashStore struct {
+	mu        sync.Mutex
+	files     map[string][]string
+	sizes     map[string]int64
+	seenInode map[[2]uint64]string
+	hardlinks map[string][]string
+}
+
+func newHashStore() *HashStore {
+	return &HashStore{
+		files:     make(map[string][]string),
+		sizes:     make(map[string]int64),
+		seenInode: make(map[[2]uint64]string),
+		hardlinks: make(map[string][]string),
+	}
+}
+
+func (s *HashStore) add(hash, path string, size int64) {
+	s.mu.Lock()
+	s.files[hash] = append(s.files[hash], path)
+	s.sizes[path] = size
+	s.mu.Unlock()
+}
+
+// checkInode records the first path seen for a given (dev, ino) pair. If
+// filePath is a second path to an inode already recorded, it is the same
+// file on disk rather than a content duplicate: checkInode files it under
+// the original path's hardlinks instead and reports isHardlink so the
+// caller can skip hashing it. Either way size is recorded in s.sizes, since
+// a hardlink never reaches store.add (which would otherwise do it) and
+// formats like --format=json report size for every path, hardlinks
+// included.
+func (s *HashStore) checkInode(dev, ino uint64, filePath string, size int64) (isHardlink bool) {
+	key := [2]uint64{dev, ino}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes[filePath] = size
+	if first, seen := s.seenInode[key]; seen {
+		s.hardlinks[first] = append(s.hardlinks[first], filePath)
+		return true
+	}
+	s.seenInode[key] = filePath
+	return false
+}
+
+// Progress bundles the shared, concurrency-safe state needed to print the
+// verbose progress bar from multiple hashing workers at once. printMu
+// serializes the actual writes so characters from different workers don't
+// interleave; count is updated atomically so the block/row math below stays
+// correct under concurrent increments.
+type Progress struct {
+	count          atomic.Int64
+	printMu        sync.Mutex
+	verbose        bool
+	stderrProgress bool
+	blockSize      int
+	rowSize        int
+}
+
+func (p *Progress) writer() io.Writer {
+	if p.stderrProgress {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+func (p *Progress) mark(symbol string) {
+	if !p.verbose {
+		return
+	}
+	p.printMu.Lock()
+	defer p.printMu.Unlock()
+	fmt.Fprint(p.writer(), symbol)
+}
+
+func (p *Progress) debugLine(fileHash, filePath string) {
+	p.printMu.Lock()
+	defer p.printMu.Unlock()
+	fmt.Fprintf(p.writer(), "%s %s\n", fileHash, filePath)
+}
+
+// advance increments the processed-file counter and, if verbose, prints the
+// block/row separators at the same cadence as the original serial code.
+func (p *Progress) advance() {
+	count := p.count.Add(1)
+	if !p.verbose {
+		return
+	}
+	if count%int64(p.blockSize) == 0 {
+		p.printMu.Lock()
+		defer p.printMu.Unlock()
+		if (count/int64(p.blockSize))%int64(p.rowSize) != 0 {
+			fmt.Fprint(p.writer(), " ")
+		} else {
+			fmt.Fprintf(p.writer(), " [%6s]\n", strconv.FormatInt(count, 10))
+		}
+	}
+}
 
 // Flag messages as constants for better maintainability
 const (
@@ -48,6 +286,15 @@ const (
 	noShowHashesUsage  = "Don't show the hashes when listing duplicates."
 	noDotUsage         = "Cut `./` from the start of names of files when listing."
 	stderrUsage        = "Send progress bars to stderr."
+	jobsUsage          = "Number of parallel hashing workers (defaults to the number of CPUs)."
+	fastUsage          = "Prune same-size files further by comparing their first 4 KiB before full-hashing."
+	minSizeUsage       = "Ignore files smaller than N bytes."
+	formatUsage        = "Output format: text, sums, or json."
+	crossDeviceUsage   = "Allow a recursive walk to descend into other filesystems/devices."
+	actionUsage        = "Action to take on duplicates: report, delete, hardlink, symlink, or script."
+	dryRunUsage        = "Print what --action would do instead of doing it."
+	keepUsage          = "Which file in a duplicate group to keep: shortest, longest, first, newest, or oldest."
+	scriptLinkUsage    = "Link type --action=script emits for duplicates: hardlink or symlink."
 )
 
 func getHash(algo string) (hash.Hash, error) {
@@ -109,52 +356,71 @@ func hashFile(filePath string, algo string) (string, error) {
 	return fmt.Sprintf("%x", h.Sum(nil)), nil
 }
 
-// ProcessOneFile now calculates a hash and updates the map
-func ProcessOneFile(filePath string, fileCount *int, verbose, storeXattr, recreateXattr, debugFlag, stderrProgress bool, blockSize, rowSize int, hashAlgo string) {
+// emptyFileHash returns the digest of zero bytes of input for algo. It
+// lets the sums/json formatters report a real digest for files recorded
+// under the "0-byte-file" sentinel instead of leaking that internal
+// placeholder into scriptable output.
+func emptyFileHash(algo string) (string, error) {
+	h, err := getHash(algo)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(strings.ToLower(algo), "shake") {
+		output := make([]byte, 64)
+		h.Sum(output[:0])
+		return fmt.Sprintf("%x", output), nil
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// ProcessOneFile hashes a single file (consulting/updating extended
+// attributes as requested) and records the result in store. It is the unit
+// of work run by each hashing worker spawned from hashPaths.
+func ProcessOneFile(filePath string, store *HashStore, prog *Progress, storeXattr, recreateXattr, debugFlag bool, hashAlgo string) {
 	info, err := os.Stat(filePath)
 	if err != nil {
 		log.Printf("Error checking file size for %s: %v", filePath, err)
-		if verbose && !stderrProgress {
-			fmt.Println("!")
-		} else if verbose && stderrProgress {
-			fmt.Fprintln(os.Stderr, "!")
-		}
-		*fileCount++
+		prog.mark("!")
+		prog.advance()
 		return
 	}
 
+	// A path that is a hardlink to one we've already processed is the same
+	// file on disk, not a content duplicate: record it and move on without
+	// hashing it again.
+	if dev, ino, ok := fileIdentity(filePath, info); ok {
+		if store.checkInode(dev, ino, filePath, info.Size()) {
+			prog.mark("h")
+			prog.advance()
+			return
+		}
+	}
+
 	// For zero-sized files, we can just use a constant hash
 	if info.Size() == 0 {
-		filesByHash["0-byte-file"] = append(filesByHash["0-byte-file"], filePath)
-		*fileCount++
-		if verbose && !stderrProgress {
-			fmt.Printf(".")
-		} else if verbose && stderrProgress {
-			fmt.Fprintf(os.Stderr, ".")
-		}
+		store.add("0-byte-file", filePath, 0)
+		prog.mark(".")
+		prog.advance()
 		return
 	}
 
 	var fileHash string
-	xattrName := "user.same-hash." + hashAlgo
 
 	// Logic for extended attributes
 	if storeXattr || recreateXattr {
 		if storeXattr && !recreateXattr {
-			// Try to get hash from extended attribute
-			xattrValue, err := xattr.Get(filePath, xattrName)
-			if err == nil {
-				fileHash = string(xattrValue)
+			// Trust the cached multihash only when it was produced with
+			// hashAlgo and the file's mtime hasn't moved since; otherwise
+			// recompute and refresh both xattrs.
+			if cachedHash, ok := readCachedHash(filePath, info, hashAlgo); ok {
+				fileHash = cachedHash
 			} else {
-				// Attribute doesn't exist or error, calculate and store it
 				fileHash, err = hashFile(filePath, hashAlgo)
 				if err != nil {
 					log.Printf("Error hashing file %s: %v", filePath, err)
 					return
 				}
-				if err := xattr.Set(filePath, xattrName, []byte(fileHash)); err != nil {
-					log.Printf("Error writing xattr for %s: %v", filePath, err)
-				}
+				writeMultihashXattr(filePath, fileHash, hashAlgo, info)
 			}
 		} else if recreateXattr {
 			// Always recalculate and overwrite
@@ -163,9 +429,7 @@ func ProcessOneFile(filePath string, fileCount *int, verbose, storeXattr, recrea
 				log.Printf("Error hashing file %s: %v", filePath, err)
 				return
 			}
-			if err := xattr.Set(filePath, xattrName, []byte(fileHash)); err != nil {
-				log.Printf("Error writing xattr for %s: %v", filePath, err)
-			}
+			writeMultihashXattr(filePath, fileHash, hashAlgo, info)
 		}
 	} else {
 		// No xattr option, just calculate the hash
@@ -176,45 +440,39 @@ func ProcessOneFile(filePath string, fileCount *int, verbose, storeXattr, recrea
 		}
 	}
 
-	filesByHash[fileHash] = append(filesByHash[fileHash], filePath)
-	*fileCount++
-
-	if verbose {
-		if !stderrProgress {
-			fmt.Printf("=")
-		} else {
-			fmt.Fprintf(os.Stderr, "=")
-		}
-	}
+	store.add(fileHash, filePath, info.Size())
+	prog.mark("=")
 	if debugFlag {
-		if !stderrProgress {
-			fmt.Printf("%s %s\n", fileHash, filePath)
-		} else {
-			fmt.Fprintf(os.Stderr, "%s %s\n", fileHash, filePath)
-		}
+		prog.debugLine(fileHash, filePath)
 	}
+	prog.advance()
+}
 
-	if verbose {
-		if *fileCount%blockSize == 0 {
-			if (*fileCount/blockSize)%rowSize != 0 {
-				if !stderrProgress {
-					fmt.Printf(" ")
-				} else {
-					fmt.Fprintf(os.Stderr, " ")
-				}
-			}
-			if (*fileCount/blockSize)%rowSize == 0 {
-				if !stderrProgress {
-					fmt.Printf(" [%6s]\n", strconv.FormatInt(int64(*fileCount), 10))
-				} else {
-					fmt.Fprintf(os.Stderr, " [%6s]\n", strconv.FormatInt(int64(*fileCount), 10))
-				}
-			}
+// fileCandidate is a regular file discovered by collectCandidates, paired
+// with the size read during the walk so phase 1 of the two-phase duplicate
+// search can bucket by size without re-statting every file.
+type fileCandidate struct {
+	path string
+	size int64
+}
+
+// collectCandidates walks path, returning every regular file it finds
+// together with its size. Traversal is kept separate from hashing so
+// hashDuplicateCandidates can skip files that cannot possibly have a
+// duplicate before ever opening them. With crossDevice false, the walk
+// refuses to descend into a directory (or follow a symlink) that resolves
+// to a different device than path itself.
+func collectCandidates(path string, recursive, followLinks, crossDevice bool) []fileCandidate {
+	var candidates []fileCandidate
+
+	var rootDev uint64
+	var rootDevKnown bool
+	if !crossDevice {
+		if rootInfo, err := os.Stat(path); err == nil {
+			rootDev, _, rootDevKnown = fileIdentity(path, rootInfo)
 		}
 	}
-}
 
-func walkAndProcess(path string, fileCount *int, verbose, recursive, followLinks, storeXattr, recreateXattr, debugFlag, stderrProgress bool, blockSize, rowSize int, hashAlgo string) {
 	walker := func(walkerPath string, d fs.DirEntry, err error) error {
 		if err != nil {
 			log.Printf("Error accessing path %s: %v", walkerPath, err)
@@ -230,6 +488,15 @@ func walkAndProcess(path string, fileCount *int, verbose, recursive, followLinks
 			return nil
 		}
 
+		if rootDevKnown {
+			if dev, _, ok := fileIdentity(walkerPath, info); ok && dev != rootDev {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
 		if info.IsDir() {
 			if !recursive && walkerPath != path {
 				return filepath.SkipDir
@@ -248,19 +515,659 @@ func walkAndProcess(path string, fileCount *int, verbose, recursive, followLinks
 					log.Printf("Error statting resolved path %s: %v", resolvedPath, err)
 					return nil
 				}
+				if rootDevKnown {
+					if dev, _, ok := fileIdentity(resolvedPath, resolvedInfo); ok && dev != rootDev {
+						return nil
+					}
+				}
 				if !resolvedInfo.IsDir() {
-					ProcessOneFile(resolvedPath, fileCount, verbose, storeXattr, recreateXattr, debugFlag, stderrProgress, blockSize, rowSize, hashAlgo)
+					candidates = append(candidates, fileCandidate{resolvedPath, resolvedInfo.Size()})
 				}
 			}
 			return nil
 		}
-		ProcessOneFile(walkerPath, fileCount, verbose, storeXattr, recreateXattr, debugFlag, stderrProgress, blockSize, rowSize, hashAlgo)
+		candidates = append(candidates, fileCandidate{walkerPath, info.Size()})
 		return nil
 	}
 	filepath.WalkDir(path, walker)
+	return candidates
+}
+
+// hashPaths runs jobs hashing workers over paths concurrently, recording
+// results into store. It is the second phase of the two-phase duplicate
+// search: unless hashDuplicateCandidates was called with hashAll, only
+// paths that survived its size (and, with --fast, head) bucketing reach
+// here.
+func hashPaths(paths []string, store *HashStore, prog *Progress, storeXattr, recreateXattr, debugFlag bool, hashAlgo string, jobs int) {
+	work := make(chan string, jobs*4)
+
+	var workers sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for filePath := range work {
+				ProcessOneFile(filePath, store, prog, storeXattr, recreateXattr, debugFlag, hashAlgo)
+			}
+		}()
+	}
+	for _, p := range paths {
+		work <- p
+	}
+	close(work)
+	workers.Wait()
+}
+
+// headPruneSize is how much of a file --fast reads to sub-bucket same-size
+// files before committing to a full hash.
+const headPruneSize = 4096
+
+// fileHead reads up to headPruneSize bytes from the start of filePath.
+func fileHead(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf := make([]byte, headPruneSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return string(buf[:n]), nil
+}
+
+// pruneByHead narrows a same-size bucket further by comparing the first
+// headPruneSize bytes of each file. A file whose head is unique within the
+// bucket cannot match anything else in it, so it is dropped (marked as
+// skipped on prog) before the much more expensive full hash runs.
+func pruneByHead(paths []string, prog *Progress) []string {
+	byHead := make(map[string][]string)
+	for _, p := range paths {
+		head, err := fileHead(p)
+		if err != nil {
+			log.Printf("Error reading head of %s: %v", p, err)
+			continue
+		}
+		byHead[head] = append(byHead[head], p)
+	}
+	var survivors []string
+	for _, group := range byHead {
+		if len(group) < 2 {
+			prog.mark("-")
+			prog.advance()
+			continue
+		}
+		survivors = append(survivors, group...)
+	}
+	return survivors
+}
+
+// hashDuplicateCandidates implements the two-phase duplicate search:
+// phase 1 buckets candidates by exact size and drops any bucket with only
+// one entry, since a file with a unique size cannot be anyone's duplicate;
+// phase 2 optionally (--fast) prunes further by comparing file heads, then
+// full-hashes only the survivors. Zero-byte files bypass bucketing
+// entirely since ProcessOneFile already short-circuits them to a constant
+// "0-byte-file" hash. Candidates smaller than minSize are skipped outright.
+//
+// hashAll disables the bucketing/pruning altogether and hashes every
+// candidate (other than minSize exclusions). Callers that need every
+// scanned file to end up in store, not just size-bucket survivors — such
+// as -X/-Y xattr caching, where skipping a unique-size file leaves its
+// cache unwritten and later trips up "same check" — must set it.
+func hashDuplicateCandidates(candidates []fileCandidate, store *HashStore, prog *Progress, storeXattr, recreateXattr, debugFlag, fast, hashAll bool, minSize int64, hashAlgo string, jobs int) {
+	var survivors []string
+
+	if hashAll {
+		for _, c := range candidates {
+			if minSize > 0 && c.size < minSize {
+				prog.mark("-")
+				prog.advance()
+				continue
+			}
+			survivors = append(survivors, c.path)
+		}
+		hashPaths(survivors, store, prog, storeXattr, recreateXattr, debugFlag, hashAlgo, jobs)
+		return
+	}
+
+	bySize := make(map[int64][]string)
+
+	for _, c := range candidates {
+		if minSize > 0 && c.size < minSize {
+			prog.mark("-")
+			prog.advance()
+			continue
+		}
+		if c.size == 0 {
+			survivors = append(survivors, c.path)
+			continue
+		}
+		bySize[c.size] = append(bySize[c.size], c.path)
+	}
+
+	for _, group := range bySize {
+		if len(group) < 2 {
+			prog.mark("-")
+			prog.advance()
+			continue
+		}
+		if fast {
+			group = pruneByHead(group, prog)
+		}
+		survivors = append(survivors, group...)
+	}
+
+	hashPaths(survivors, store, prog, storeXattr, recreateXattr, debugFlag, hashAlgo, jobs)
+}
+
+// checkStatus is the per-file verdict printed by runCheck.
+type checkStatus string
+
+const (
+	checkOK      checkStatus = "OK"
+	checkChanged checkStatus = "CHANGED"
+	checkMissing checkStatus = "MISSING"
+)
+
+// checkPath verifies a single file against its stored multihash, inferring
+// the hashing algorithm from the multihash itself rather than requiring -a.
+func checkPath(filePath string) (checkStatus, error) {
+	algo, storedHash, err := readMultihashXattr(filePath)
+	if err != nil {
+		return checkMissing, nil
+	}
+	currentHash, err := hashFile(filePath, algo)
+	if err != nil {
+		return "", err
+	}
+	if currentHash != storedHash {
+		return checkChanged, nil
+	}
+	return checkOK, nil
+}
+
+// runCheck walks paths and reports OK/CHANGED/MISSING for each regular
+// file found, based on the multihash stored in user.same.multihash. It is
+// the `same check` subcommand, analogous to the xsfCheck tool that inspired
+// the multihash-based cache: neither needs -a, since the stored multihash
+// already names its own algorithm.
+func runCheck(paths []string, recursive, followLinks bool) {
+	report := func(filePath string) {
+		status, err := checkPath(filePath)
+		if err != nil {
+			log.Printf("Error checking %s: %v", filePath, err)
+			return
+		}
+		fmt.Printf("%-7s %s\n", status, filePath)
+	}
+
+	walker := func(root string) filepath.WalkFunc {
+		return func(walkerPath string, info os.FileInfo, err error) error {
+			if err != nil {
+				log.Printf("Error accessing path %s: %v", walkerPath, err)
+				return nil
+			}
+			if info.IsDir() {
+				if !recursive && walkerPath != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				if !followLinks {
+					return nil
+				}
+				resolvedPath, err := filepath.EvalSymlinks(walkerPath)
+				if err != nil {
+					log.Printf("Error following symlink %s: %v", walkerPath, err)
+					return nil
+				}
+				report(resolvedPath)
+				return nil
+			}
+			report(walkerPath)
+			return nil
+		}
+	}
+
+	for _, path := range paths {
+		info, err := os.Lstat(path)
+		if err != nil {
+			log.Printf("Error accessing path %s: %v", path, err)
+			continue
+		}
+		if info.IsDir() {
+			filepath.Walk(path, walker(path))
+		} else {
+			report(path)
+		}
+	}
+}
+
+// Formatter renders the collected hashes in one of the --format styles.
+// Only the final reporting step in main differs between formats; walking,
+// hashing, and xattr caching are identical regardless of which one is
+// picked.
+type Formatter interface {
+	Render(w io.Writer) error
+}
+
+// textFormatter reproduces the original human-readable report: grouped by
+// hash, with the shortest path elided as the implicit "keeper" when
+// duplicatesFlag is set, or listed plainly for the older -v-only fallback.
+type textFormatter struct {
+	store          *HashStore
+	duplicatesFlag bool
+	skipZeroSized  bool
+	noShowHashes   bool
+	noDot          bool
+	stderrProgress bool
+}
+
+func (f textFormatter) Render(w io.Writer) error {
+	if !f.stderrProgress {
+		fmt.Fprintln(w, "\n--- Duplicate files found ---")
+	}
+	foundDuplicates := false
+	for h, paths := range f.store.files {
+		if h == "0-byte-file" && f.skipZeroSized {
+			continue
+		}
+		if len(paths) <= 1 {
+			continue
+		}
+		foundDuplicates = true
+		if !f.noShowHashes {
+			fmt.Fprintf(w, "%s:\n", h)
+		}
+		if !f.duplicatesFlag {
+			for _, p := range paths {
+				if f.noDot {
+					p = strings.TrimPrefix(p, "./")
+				}
+				fmt.Fprintf(w, "  %s\n", p)
+			}
+			fmt.Fprintln(w)
+			continue
+		}
+
+		sort.Slice(paths, func(i, j int) bool {
+			lenI := len(paths[i])
+			lenJ := len(paths[j])
+			if lenI != lenJ {
+				return lenI < lenJ
+			}
+			baseI := filepath.Base(paths[i])
+			baseJ := filepath.Base(paths[j])
+			return len(baseI) < len(baseJ)
+		})
+		// Check for files with the same shortest path and basename length
+		shortestPaths := []string{}
+		shortestPathLength := len(paths[0])
+		shortestBasenameLength := len(filepath.Base(paths[0]))
+		for _, p := range paths {
+			if len(p) == shortestPathLength && len(filepath.Base(p)) == shortestBasenameLength {
+				shortestPaths = append(shortestPaths, p)
+			}
+		}
+
+		for _, p := range paths {
+			if f.noDot {
+				p = strings.TrimPrefix(p, "./")
+			}
+			isShortest := false
+			for _, sp := range shortestPaths {
+				if p == sp {
+					isShortest = true
+					break
+				}
+			}
+			if len(shortestPaths) > 1 && isShortest {
+				fmt.Fprintf(w, "  %s Ã—\n", p)
+			} else if len(shortestPaths) == 1 && isShortest {
+				continue // Skip the single shortest file
+			} else {
+				fmt.Fprintf(w, "  %s\n", p)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	if !foundDuplicates {
+		fmt.Fprintln(w, "No duplicate files found.")
+	}
+
+	if len(f.store.hardlinks) > 0 {
+		fmt.Fprintln(w, "\n--- Hardlinks found ---")
+		keepers := make([]string, 0, len(f.store.hardlinks))
+		for keeper := range f.store.hardlinks {
+			keepers = append(keepers, keeper)
+		}
+		sort.Strings(keepers)
+		for _, keeper := range keepers {
+			links := append([]string(nil), f.store.hardlinks[keeper]...)
+			sort.Strings(links)
+			k := keeper
+			if f.noDot {
+				k = strings.TrimPrefix(k, "./")
+			}
+			fmt.Fprintf(w, "%s\n", k)
+			for _, p := range links {
+				if f.noDot {
+					p = strings.TrimPrefix(p, "./")
+				}
+				fmt.Fprintf(w, "  %s\n", p)
+			}
+			fmt.Fprintln(w)
+		}
+	}
+	return nil
+}
+
+// resolvedHashes returns, for every path recorded in store, the real
+// digest it should be reported under: each path hashed via store.files,
+// substituting a genuine empty-input digest for the internal
+// "0-byte-file" sentinel, plus every hardlinked path recorded in
+// store.hardlinks under its keeper's digest (hardlinks are never hashed
+// themselves, since their content is by definition identical to the
+// file they link to). Used by sumsFormatter and jsonFormatter so neither
+// machine-readable format silently drops a scanned file.
+func resolvedHashes(store *HashStore, hashAlgo string) (map[string]string, error) {
+	hashOf := make(map[string]string, len(store.sizes))
+	for h, paths := range store.files {
+		hash := h
+		if h == "0-byte-file" {
+			var err error
+			hash, err = emptyFileHash(hashAlgo)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, p := range paths {
+			hashOf[p] = hash
+		}
+	}
+	for keeper, links := range store.hardlinks {
+		hash, ok := hashOf[keeper]
+		if !ok {
+			continue
+		}
+		for _, p := range links {
+			hashOf[p] = hash
+		}
+	}
+	return hashOf, nil
+}
+
+// sumsFormatter emits one line per hashed file in GNU sha256sum-compatible
+// form, so the output can be piped straight into `sha256sum -c` (given the
+// matching -a algorithm).
+type sumsFormatter struct {
+	store    *HashStore
+	hashAlgo string
+	noDot    bool
+}
+
+func (f sumsFormatter) Render(w io.Writer) error {
+	hashOf, err := resolvedHashes(f.store, f.hashAlgo)
+	if err != nil {
+		return err
+	}
+
+	type line struct{ hash, path string }
+	lines := make([]line, 0, len(hashOf))
+	for p, hash := range hashOf {
+		lines = append(lines, line{hash, p})
+	}
+	sort.Slice(lines, func(i, j int) bool { return lines[i].path < lines[j].path })
+	for _, l := range lines {
+		p := l.path
+		if f.noDot {
+			p = strings.TrimPrefix(p, "./")
+		}
+		fmt.Fprintf(w, "%s  %s\n", l.hash, p)
+	}
+	return nil
+}
+
+// jsonFile and jsonDuplicate are the "files" and "duplicates" entries of
+// the document emitted by jsonFormatter.
+type jsonFile struct {
+	Hash string `json:"hash"`
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+type jsonDuplicate struct {
+	Hash  string   `json:"hash"`
+	Paths []string `json:"paths"`
+}
+
+// jsonHardlink is one keeper/links entry of the "hardlinks" section of the
+// document emitted by jsonFormatter, mirroring the "--- Hardlinks found
+// ---" grouping textFormatter prints.
+type jsonHardlink struct {
+	Path  string   `json:"path"`
+	Links []string `json:"links"`
+}
+
+// jsonFormatter emits a single JSON document describing every hashed file,
+// the duplicate groups among them, and any hardlinks found, for scripting
+// and CI pipelines.
+type jsonFormatter struct {
+	store    *HashStore
+	hashAlgo string
+}
+
+func (f jsonFormatter) Render(w io.Writer) error {
+	hashOf, err := resolvedHashes(f.store, f.hashAlgo)
+	if err != nil {
+		return err
+	}
+
+	doc := struct {
+		Algo       string          `json:"algo"`
+		Files      []jsonFile      `json:"files"`
+		Duplicates []jsonDuplicate `json:"duplicates"`
+		Hardlinks  []jsonHardlink  `json:"hardlinks"`
+	}{Algo: f.hashAlgo}
+
+	for p, hash := range hashOf {
+		doc.Files = append(doc.Files, jsonFile{Hash: hash, Path: p, Size: f.store.sizes[p]})
+	}
+	for _, paths := range f.store.files {
+		if len(paths) <= 1 {
+			continue
+		}
+		hash := hashOf[paths[0]]
+		sortedPaths := append([]string(nil), paths...)
+		sort.Strings(sortedPaths)
+		doc.Duplicates = append(doc.Duplicates, jsonDuplicate{Hash: hash, Paths: sortedPaths})
+	}
+	keepers := make([]string, 0, len(f.store.hardlinks))
+	for keeper := range f.store.hardlinks {
+		keepers = append(keepers, keeper)
+	}
+	sort.Strings(keepers)
+	for _, keeper := range keepers {
+		links := append([]string(nil), f.store.hardlinks[keeper]...)
+		sort.Strings(links)
+		doc.Hardlinks = append(doc.Hardlinks, jsonHardlink{Path: keeper, Links: links})
+	}
+
+	sort.Slice(doc.Files, func(i, j int) bool { return doc.Files[i].Path < doc.Files[j].Path })
+	sort.Slice(doc.Duplicates, func(i, j int) bool { return doc.Duplicates[i].Hash < doc.Duplicates[j].Hash })
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
+
+// selectKeeper picks which file in a duplicate group --action keeps,
+// according to keepPolicy, and returns the rest as the files to act on.
+// "shortest" reproduces the path-length/basename-length ordering
+// textFormatter already used to elide the implicit keeper.
+func selectKeeper(paths []string, keepPolicy string) (keeper string, duplicates []string) {
+	paths = append([]string(nil), paths...)
+	switch strings.ToLower(keepPolicy) {
+	case "longest":
+		sort.Slice(paths, func(i, j int) bool { return len(paths[i]) > len(paths[j]) })
+	case "first":
+		sort.Strings(paths)
+	case "newest":
+		sort.Slice(paths, func(i, j int) bool { return mtimeOf(paths[i]).After(mtimeOf(paths[j])) })
+	case "oldest":
+		sort.Slice(paths, func(i, j int) bool { return mtimeOf(paths[i]).Before(mtimeOf(paths[j])) })
+	default: // "shortest"
+		sort.Slice(paths, func(i, j int) bool {
+			lenI, lenJ := len(paths[i]), len(paths[j])
+			if lenI != lenJ {
+				return lenI < lenJ
+			}
+			return len(filepath.Base(paths[i])) < len(filepath.Base(paths[j]))
+		})
+	}
+	return paths[0], paths[1:]
+}
+
+// mtimeOf returns path's ModTime, or the zero Time if it can no longer be
+// statted. Used only to order candidates for --keep=newest/oldest.
+func mtimeOf(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// shellQuote wraps s in single quotes for safe use in the shell script
+// emitted by --action=script.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// runAction applies action to every duplicate group in store, keeping one
+// path per group per keepPolicy. This is the pluggable replacement for the
+// old hard-coded "report duplicates" block: report is just the action that
+// does nothing to the filesystem, the others turn same into a real
+// deduplication tool. skipZeroSized, same as for textFormatter, excludes
+// the "0-byte-file" sentinel group so --skip-zero also protects empty
+// files (an all-but-one-zero-length-file delete is rarely what's wanted)
+// from --action=delete/hardlink/symlink/script.
+func runAction(store *HashStore, action, keepPolicy string, dryRun, skipZeroSized bool, scriptLink string, w io.Writer) error {
+	hashes := make([]string, 0, len(store.files))
+	for h := range store.files {
+		hashes = append(hashes, h)
+	}
+	sort.Strings(hashes)
+
+	for _, h := range hashes {
+		if h == "0-byte-file" && skipZeroSized {
+			continue
+		}
+		paths := store.files[h]
+		if len(paths) <= 1 {
+			continue
+		}
+		keeper, duplicates := selectKeeper(paths, keepPolicy)
+		for _, dup := range duplicates {
+			if err := applyAction(action, keeper, dup, dryRun, scriptLink, w); err != nil {
+				log.Printf("Error applying --action=%s to %s: %v", action, dup, err)
+			}
+		}
+	}
+	return nil
+}
+
+// replaceWithLink atomically replaces dup with a hardlink (or, if symlink
+// is true, a symlink) to keeper. The new link is built at a temporary path
+// next to dup and renamed over it, rather than removing dup first: a
+// failure creating the link - e.g. EXDEV across a filesystem boundary, or
+// a permission error - then leaves dup untouched instead of deleted with
+// nothing to replace it.
+func replaceWithLink(dup, keeper string, symlink bool) error {
+	tmp := dup + ".same-tmp"
+	var err error
+	if symlink {
+		// A relative target would resolve against dup's directory, so
+		// point the symlink at keeper's absolute path.
+		target, absErr := filepath.Abs(keeper)
+		if absErr != nil {
+			return absErr
+		}
+		err = os.Symlink(target, tmp)
+	} else {
+		err = os.Link(keeper, tmp)
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dup); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// applyAction performs (or, if dryRun, describes) action on a single
+// duplicate path, given the keeper it would be replaced by/linked to.
+// scriptLink only matters for action "script": it picks which link form
+// (hardlink or symlink) the emitted commands reproduce.
+func applyAction(action, keeper, dup string, dryRun bool, scriptLink string, w io.Writer) error {
+	switch strings.ToLower(action) {
+	case "report":
+		return nil
+	case "delete":
+		if dryRun {
+			fmt.Fprintf(w, "Would delete %s (keeping %s)\n", dup, keeper)
+			return nil
+		}
+		return os.Remove(dup)
+	case "hardlink":
+		if dryRun {
+			fmt.Fprintf(w, "Would hardlink %s -> %s\n", dup, keeper)
+			return nil
+		}
+		return replaceWithLink(dup, keeper, false)
+	case "symlink":
+		if dryRun {
+			fmt.Fprintf(w, "Would symlink %s -> %s\n", dup, keeper)
+			return nil
+		}
+		return replaceWithLink(dup, keeper, true)
+	case "script":
+		fmt.Fprintf(w, "rm -f -- %s\n", shellQuote(dup))
+		if strings.ToLower(scriptLink) == "symlink" {
+			fmt.Fprintf(w, "ln -s -- %s %s\n", shellQuote(keeper), shellQuote(dup))
+		} else {
+			fmt.Fprintf(w, "ln -- %s %s\n", shellQuote(keeper), shellQuote(dup))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown --action %q", action)
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		checkFlags := flag.NewFlagSet("check", flag.ExitOnError)
+		recursive := checkFlags.Bool("r", false, recursiveUsage)
+		checkFlags.BoolVar(recursive, "recursive", false, recursiveUsage)
+		followLinks := checkFlags.Bool("l", false, followLinksUsage)
+		checkFlags.BoolVar(followLinks, "follow-links", false, followLinksUsage)
+		checkFlags.Parse(os.Args[2:])
+		paths := checkFlags.Args()
+		if len(paths) == 0 {
+			fmt.Println("Usage: same check [options] <path1> <path2> ...")
+			checkFlags.PrintDefaults()
+			os.Exit(1)
+		}
+		runCheck(paths, *recursive, *followLinks)
+		return
+	}
+
 	var verboseFlag bool
 	var recursiveFlag bool
 	var availableFlag bool
@@ -278,6 +1185,15 @@ func main() {
 	var noShowHashes bool
 	var noDot bool
 	var stderrProgress bool
+	var jobs int
+	var fastFlag bool
+	var minSize int64
+	var outputFormat string
+	var crossDeviceFlag bool
+	var actionFlag string
+	var dryRunFlag bool
+	var keepFlag string
+	var scriptLink string
 
 	flag.StringVar(&hashAlgo, "a", "sha512", hashAlgoUsage)
 	flag.BoolVar(&availableFlag, "A", false, availableUsage)
@@ -312,9 +1228,34 @@ func main() {
 	flag.BoolVar(&noDot, "nodot", false, noDotUsage)
 	flag.BoolVar(&stderrProgress, "stderr-progress", false, stderrUsage)
 	flag.BoolVar(&stderrProgress, "stderr", false, stderrUsage)
+	flag.IntVar(&jobs, "j", runtime.NumCPU(), jobsUsage)
+	flag.IntVar(&jobs, "jobs", runtime.NumCPU(), jobsUsage)
+	flag.BoolVar(&fastFlag, "fast", false, fastUsage)
+	flag.Int64Var(&minSize, "min-size", 0, minSizeUsage)
+	flag.StringVar(&outputFormat, "format", "text", formatUsage)
+	flag.BoolVar(&crossDeviceFlag, "cross-device", true, crossDeviceUsage)
+	flag.StringVar(&actionFlag, "action", "report", actionUsage)
+	flag.BoolVar(&dryRunFlag, "dry-run", true, dryRunUsage)
+	flag.StringVar(&keepFlag, "keep", "shortest", keepUsage)
+	flag.StringVar(&scriptLink, "script-link", "hardlink", scriptLinkUsage)
 
 	flag.Parse()
 
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	// md4, ripemd160, sha224, and sha384 have no standardized multihash
+	// code (see multihashCodes), so -X/-Y can't cache a digest for them at
+	// all. Rather than let every file silently fail to cache and log its
+	// own "algorithm ... has no multihash code" line, reject the
+	// combination once, up front.
+	if storeXattr || recreateXattr {
+		if _, ok := multihashCodes[strings.ToLower(hashAlgo)]; !ok {
+			log.Fatalf("--store-xattr/--always-recreate-xattr: algorithm %s has no multihash code and cannot be cached; pick another algorithm (see -A)", hashAlgo)
+		}
+	}
+
 	// If debug mode is enabled, override other output flags
 	if debugFlag {
 		verboseFlag = false
@@ -364,7 +1305,16 @@ func main() {
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
-	var processedFileCount int
+
+	store := newHashStore()
+	prog := &Progress{
+		verbose:        verboseFlag,
+		stderrProgress: stderrProgress,
+		blockSize:      blockSize,
+		rowSize:        rowSize,
+	}
+
+	var candidates []fileCandidate
 	for _, path := range paths {
 		info, err := os.Lstat(path)
 		if err != nil {
@@ -389,99 +1339,49 @@ func main() {
 			}
 		}
 		if info.IsDir() {
-			walkAndProcess(path, &processedFileCount, verboseFlag, recursiveFlag, followLinksFlag, storeXattr, recreateXattr, debugFlag, stderrProgress, blockSize, rowSize, hashAlgo)
+			candidates = append(candidates, collectCandidates(path, recursiveFlag, followLinksFlag, crossDeviceFlag)...)
 		} else {
-			ProcessOneFile(path, &processedFileCount, verboseFlag, storeXattr, recreateXattr, debugFlag, stderrProgress, blockSize, rowSize, hashAlgo)
+			candidates = append(candidates, fileCandidate{path, info.Size()})
 		}
 	}
 
-	if duplicatesFlag {
-		if !stderrProgress {
-			fmt.Println("\n--- Duplicate files found ---")
-		}
-		foundDuplicates := false
-		for h, paths := range filesByHash {
-			if h == "0-byte-file" && skipZeroSized {
-				continue
-			}
-			if len(paths) > 1 {
-				foundDuplicates = true
-				if !noShowHashes {
-					fmt.Printf("%s:\n", h)
-				}
-				sort.Slice(paths, func(i, j int) bool {
-					lenI := len(paths[i])
-					lenJ := len(paths[j])
-					if lenI != lenJ {
-						return lenI < lenJ
-					}
-					baseI := filepath.Base(paths[i])
-					baseJ := filepath.Base(paths[j])
-					return len(baseI) < len(baseJ)
-				})
-				// Check for files with the same shortest path and basename length
-				shortestPaths := []string{}
-				if len(paths) > 0 {
-					shortestPathLength := len(paths[0])
-					shortestBasenameLength := len(filepath.Base(paths[0]))
-					for _, p := range paths {
-						if len(p) == shortestPathLength && len(filepath.Base(p)) == shortestBasenameLength {
-							shortestPaths = append(shortestPaths, p)
-						}
-					}
-				}
-
-				for _, p := range paths {
-					if noDot {
-						p = strings.TrimPrefix(p, "./")
-					}
-					isShortest := false
-					for _, sp := range shortestPaths {
-						if p == sp {
-							isShortest = true
-							break
-						}
-					}
-					if len(shortestPaths) > 1 && isShortest {
-						fmt.Printf("  %s Ã—\n", p)
-					} else if len(shortestPaths) == 1 && isShortest {
-						continue // Skip the single shortest file
-					} else {
-						fmt.Printf("  %s\n", p)
-					}
-				}
-				fmt.Println()
-			}
-		}
+	// Phase 1/2: bucket by size (and, with --fast, by head) across every
+	// path given on the command line, then hash only the survivors. -X/-Y
+	// and the machine-readable formats need every scanned file hashed and
+	// recorded, so they bypass the bucketing/pruning entirely: a file with
+	// a unique size is still a real scanned file and --format=sums/json
+	// must not silently drop it.
+	lowerFormat := strings.ToLower(outputFormat)
+	hashAll := storeXattr || recreateXattr || lowerFormat == "sums" || lowerFormat == "json"
+	hashDuplicateCandidates(candidates, store, prog, storeXattr, recreateXattr, debugFlag, fastFlag, hashAll, minSize, hashAlgo, jobs)
+	processedFileCount := int(prog.count.Load())
 
-		if !foundDuplicates {
-			fmt.Println("No duplicate files found.")
-		}
-	} else if verboseFlag { // Fallback to old behavior if -d is not used but -v is
-		if !stderrProgress {
-			fmt.Println("\n--- Duplicate files found ---")
+	if strings.ToLower(actionFlag) != "report" {
+		if err := runAction(store, actionFlag, keepFlag, dryRunFlag, skipZeroSized, scriptLink, os.Stdout); err != nil {
+			log.Printf("Error running --action=%s: %v", actionFlag, err)
 		}
-		foundDuplicates := false
-		for h, paths := range filesByHash {
-			if h == "0-byte-file" && skipZeroSized {
-				continue
+	} else {
+		switch strings.ToLower(outputFormat) {
+		case "json":
+			f := jsonFormatter{store: store, hashAlgo: hashAlgo}
+			if err := f.Render(os.Stdout); err != nil {
+				log.Printf("Error rendering JSON output: %v", err)
 			}
-			if len(paths) > 1 {
-				foundDuplicates = true
-				if !noShowHashes {
-					fmt.Printf("%s:\n", h)
-				}
-				for _, p := range paths {
-					if noDot {
-						p = strings.TrimPrefix(p, "./")
-					}
-					fmt.Printf("  %s\n", p)
-				}
-				fmt.Println()
+		case "sums":
+			f := sumsFormatter{store: store, hashAlgo: hashAlgo, noDot: noDot}
+			if err := f.Render(os.Stdout); err != nil {
+				log.Printf("Error rendering sums output: %v", err)
 			}
-		}
-		if !foundDuplicates {
-			fmt.Println("No duplicate files found.")
+		case "", "text":
+			if duplicatesFlag {
+				f := textFormatter{store: store, duplicatesFlag: true, skipZeroSized: skipZeroSized, noShowHashes: noShowHashes, noDot: noDot, stderrProgress: stderrProgress}
+				f.Render(os.Stdout)
+			} else if verboseFlag { // Fallback to old behavior if -d is not used but -v is
+				f := textFormatter{store: store, duplicatesFlag: false, skipZeroSized: skipZeroSized, noShowHashes: noShowHashes, noDot: noDot, stderrProgress: stderrProgress}
+				f.Render(os.Stdout)
+			}
+		default:
+			log.Fatalf("Unknown --format %q (want text, sums, or json)", outputFormat)
 		}
 	}
 